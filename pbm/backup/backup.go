@@ -0,0 +1,210 @@
+package backup
+
+import (
+	"io"
+	"runtime"
+
+	"github.com/mongodb/mongo-tools-common/db"
+	"github.com/mongodb/mongo-tools-common/options"
+	"github.com/mongodb/mongo-tools/mongodump"
+	"github.com/pkg/errors"
+
+	"github.com/sbstp/percona-backup-mongodb/pbm"
+	"github.com/sbstp/percona-backup-mongodb/pbm/crypto"
+	"github.com/sbstp/percona-backup-mongodb/pbm/ratelimit"
+)
+
+// Backup drives a single replset's backup: a mongodump of the data plus the
+// oplog slice covering the time it took to run, both rate limited, optionally
+// encrypted and checksummed the same way a PITR chunk is before they reach
+// storage. Cluster-wide coordination (electing a leader, waiting on other
+// shards) is the agent's job and lives above this, same as it does for Restore.
+type Backup struct {
+	cn   *pbm.PBM
+	node *pbm.Node
+}
+
+// New creates a new Backup object
+func New(cn *pbm.PBM, node *pbm.Node) *Backup {
+	return &Backup{
+		cn:   cn,
+		node: node,
+	}
+}
+
+// Run takes the backup described by cmd for this node's replset, uploads the
+// dump and oplog artifacts to storage, and records the result in cmd.Name's
+// BackupMeta so restore can find and verify them later.
+func (b *Backup) Run(cmd pbm.BackupCmd) error {
+	stg, err := b.cn.GetStorage()
+	if err != nil {
+		return errors.Wrap(err, "get backup storage")
+	}
+
+	im, err := b.node.GetIsMaster()
+	if err != nil {
+		return errors.Wrap(err, "get isMaster data")
+	}
+	rsName := im.SetName
+	if rsName == "" {
+		rsName = pbm.NoReplset
+	}
+
+	ot := NewOplog(b.node)
+	startTS, err := ot.LastWrite()
+	if err != nil {
+		return errors.Wrap(err, "get start cluster time")
+	}
+
+	// BackupEncryption is one cluster-wide DEK shared by every replset: restore
+	// decrypts every shard's artifacts with the single Encryption recorded on
+	// BackupMeta. Publishing that DEK to every shard before any of them starts
+	// dumping requires a leader handshake this package doesn't implement, so
+	// refuse to silently produce shard backups nobody can ever decrypt.
+	var dek crypto.Sensitive
+	var enc pbm.BackupEncryption
+	if ks := b.cn.KeySource(); ks != nil {
+		if im.IsSharded() {
+			return errors.New("client-side encryption is not supported on sharded clusters yet: " +
+				"there is no handshake to publish one shared DEK to every shard before they start dumping")
+		}
+
+		var wrapped []byte
+		dek, wrapped, err = ks.GenerateDEK()
+		if err != nil {
+			return errors.Wrap(err, "generate backup encryption key")
+		}
+		enc = pbm.BackupEncryption{Algorithm: crypto.AlgorithmAES256GCM, WrappedDEK: wrapped}
+	}
+
+	cfg, err := b.cn.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get pbm config")
+	}
+	rl := ratelimit.Effective(cmd.RateLimitMBs, cfg.Backup.RateLimitMBs)
+
+	dumpName := rsName + ".dump"
+	_, dumpChunks, err := sealedWrite(stg, dumpName, dek, rl, func(w io.Writer) error {
+		return b.dump(w, cmd.Concurrency)
+	})
+	if err != nil {
+		return errors.Wrap(err, "backup mongodump")
+	}
+
+	endTS, err := ot.LastWrite()
+	if err != nil {
+		return errors.Wrap(err, "get end cluster time")
+	}
+	ot.SetTailingSpan(startTS, endTS)
+
+	// dbHashes has to be taken right here, at endTS, before the (possibly
+	// slow) oplog upload below: restore compares against these only after
+	// replaying the oplog up to endTS/LastWriteTS (never past it to a
+	// point-in-time target), so hashing any later than this would bake in writes
+	// the restored dataset never sees and --checksum=after would flag as
+	// corruption that never actually happened.
+	hashes, err := b.dbHashes()
+	if err != nil {
+		return errors.Wrap(err, "compute dbHashes")
+	}
+
+	oplogName := rsName + ".oplog"
+	_, oplogChunks, err := sealedWrite(stg, oplogName, dek, rl, func(w io.Writer) error {
+		_, err := ot.WriteTo(b.cn.Context(), w)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "backup oplog slice")
+	}
+
+	rs := pbm.BackupReplset{
+		Name:        rsName,
+		DumpName:    dumpName,
+		DumpChunks:  dumpChunks,
+		OplogName:   oplogName,
+		OplogChunks: oplogChunks,
+		StartTS:     startTS,
+		LastWriteTS: endTS,
+		DBHashes:    hashes,
+	}
+
+	if err := b.cn.AddBackupRSMeta(cmd.Name, rs); err != nil {
+		return errors.Wrap(err, "save replset backup metadata")
+	}
+
+	// Encryption is a single cluster-wide DEK recorded once on BackupMeta
+	// itself, not per replset, so only the leader writes it
+	if im.IsLeader() {
+		if err := b.cn.SetBackupEncryption(cmd.Name, enc); err != nil {
+			return errors.Wrap(err, "save backup encryption metadata")
+		}
+	}
+	return nil
+}
+
+// dump streams a mongodump archive of every database except PBM's own
+// control collections to w, dumping up to concurrency collections in
+// parallel (cmd.Concurrency, defaulting to the host's CPU count when <= 0).
+//
+// This only parallelizes within this replset's own dump: fanning the dump
+// out across replsets is the agent's job, same as the rest of the
+// cluster-wide coordination this package stays out of.
+func (b *Backup) dump(w io.Writer, concurrency int) error {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	topts := options.ToolOptions{
+		AppName:    "mongodump",
+		VersionStr: "0.0.1",
+		URI:        &options.URI{ConnectionString: b.node.ConnURI()},
+		Auth:       &options.Auth{},
+		Namespace:  &options.Namespace{},
+		Connection: &options.Connection{},
+		Direct:     true,
+	}
+
+	dsession, err := db.NewSessionProvider(topts)
+	if err != nil {
+		return errors.Wrap(err, "create session for the dump")
+	}
+
+	md := mongodump.MongoDump{
+		ToolOptions: &topts,
+		OutputOptions: &mongodump.OutputOptions{
+			Archive:                "-",
+			NumParallelCollections: concurrency,
+		},
+		InputOptions:    &mongodump.InputOptions{},
+		SessionProvider: dsession,
+		OutputWriter:    w,
+	}
+
+	if err := md.Init(); err != nil {
+		return errors.Wrap(err, "init mongodump")
+	}
+	return errors.Wrap(md.Dump(), "run mongodump")
+}
+
+// dbHashes runs dbHash against every user database, giving restore a
+// reading per DB it can later compare its own dbHash against to detect
+// silent corruption (see restore.ChecksumModeAfter).
+func (b *Backup) dbHashes() (map[string]string, error) {
+	dbs, err := b.node.ListDatabases()
+	if err != nil {
+		return nil, errors.Wrap(err, "list databases")
+	}
+
+	hashes := make(map[string]string, len(dbs))
+	for _, name := range dbs {
+		if name == "admin" || name == "local" || name == "config" || name == pbm.DB {
+			continue
+		}
+		h, err := b.node.DBHash(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "dbHash %s", name)
+		}
+		hashes[name] = h
+	}
+	return hashes, nil
+}
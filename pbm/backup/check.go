@@ -0,0 +1,65 @@
+package backup
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/sbstp/percona-backup-mongodb/pbm"
+	"github.com/sbstp/percona-backup-mongodb/pbm/checksum"
+	"github.com/sbstp/percona-backup-mongodb/pbm/crypto"
+	"github.com/sbstp/percona-backup-mongodb/pbm/storage"
+)
+
+// Check re-hashes a backup's dump and oplog artifacts in storage and
+// compares them against the digests recorded at backup time, without
+// actually restoring anything. It backs the `pbm check <backup>` command.
+func Check(cn *pbm.PBM, stg storage.Storage, bcpName string) error {
+	bcp, err := cn.GetBackupMeta(bcpName)
+	if err != nil {
+		return errors.Wrap(err, "get backup metadata")
+	}
+
+	// digests are taken over the plaintext, so encrypted artifacts are
+	// decrypted before they're re-hashed
+	var dek crypto.Sensitive
+	if bcp.Encryption.Algorithm != "" {
+		ks := cn.KeySource()
+		if ks == nil {
+			return errors.New("backup is encrypted but no key source is configured")
+		}
+		if dek, err = ks.UnwrapDEK(bcp.Encryption.WrappedDEK); err != nil {
+			return errors.Wrap(err, "unwrap data encryption key")
+		}
+	}
+
+	for _, rs := range bcp.Replsets {
+		if err := checkObject(stg, rs.DumpName, rs.DumpChunks, dek); err != nil {
+			return errors.Wrapf(err, "replset %s: dump", rs.Name)
+		}
+		if err := checkObject(stg, rs.OplogName, rs.OplogChunks, dek); err != nil {
+			return errors.Wrapf(err, "replset %s: oplog", rs.Name)
+		}
+	}
+
+	return nil
+}
+
+func checkObject(stg storage.Storage, name string, expected []checksum.ChunkDigest, dek crypto.Sensitive) error {
+	r, err := stg.SourceReader(name)
+	if err != nil {
+		return errors.Wrapf(err, "get object %s", name)
+	}
+	defer r.Close()
+
+	var src io.Reader = r
+	if dek != nil {
+		if src, err = crypto.NewDecryptor(r, dek); err != nil {
+			return errors.Wrapf(err, "decrypt object %s", name)
+		}
+	}
+	vr := checksum.NewVerifyReader(src, expected)
+	_, err = io.Copy(ioutil.Discard, vr)
+	return errors.Wrapf(err, "verify object %s", name)
+}
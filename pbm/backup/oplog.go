@@ -3,6 +3,8 @@ package backup
 import (
 	"context"
 	"io"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
@@ -10,8 +12,23 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/sbstp/percona-backup-mongodb/pbm"
+	"github.com/sbstp/percona-backup-mongodb/pbm/checksum"
+	"github.com/sbstp/percona-backup-mongodb/pbm/crypto"
+	"github.com/sbstp/percona-backup-mongodb/pbm/ratelimit"
+	"github.com/sbstp/percona-backup-mongodb/pbm/storage"
 )
 
+// ChunkSpan is the span of cluster time covered by a single oplog chunk
+// written by WriteChunks: [start, start+ChunkSpan]. A chunk is closed at the
+// first record observed past that boundary (which goes into the next chunk)
+// or once wall time reaches it with nothing newer to read.
+const ChunkSpan = 10 * time.Minute
+
+// tailAwait is how long a single getMore on the tailing cursor blocks
+// waiting for a new record before WriteTo re-checks whether real time has
+// caught up with the chunk's end boundary.
+const tailAwait = 2 * time.Second
+
 // Oplog is used for reading the Mongodb oplog
 type Oplog struct {
 	node  *pbm.Node
@@ -37,13 +54,18 @@ func (ot *Oplog) SetTailingSpan(start, end primitive.Timestamp) {
 // To be sure we have read ALL records up to the specified cluster time.
 // Specifically, to be sure that no operations from the past gonna came after we finished the slicing,
 // we have to tail until some record with ts > endTS. And it might be a noop.
-func (ot *Oplog) WriteTo(w io.Writer) (int64, error) {
+//
+// The underlying cursor is TailableAwait so a getMore blocks (up to
+// tailAwait) for new data instead of returning as soon as the collection is
+// momentarily drained. If end is in the future and the oplog simply has
+// nothing more to give right now, we keep re-issuing getMores until real
+// time catches up with end: only then is it safe to call the slice
+// complete, since no op can still be pending for a ts we've already passed.
+func (ot *Oplog) WriteTo(ctx context.Context, w io.Writer) (int64, error) {
 	if ot.start.T == 0 || ot.end.T == 0 {
 		return 0, errors.Errorf("oplog TailingSpan should be set, have start: %v, end: %v", ot.start, ot.end)
 	}
 
-	ctx := context.Background()
-
 	clName, err := ot.collectionName()
 	if err != nil {
 		return 0, errors.Wrap(err, "determine oplog collection name")
@@ -54,7 +76,7 @@ func (ot *Oplog) WriteTo(w io.Writer) (int64, error) {
 		bson.M{
 			"ts": bson.M{"$gte": ot.start},
 		},
-		options.Find().SetCursorType(options.Tailable),
+		options.Find().SetCursorType(options.TailableAwait).SetMaxAwaitTime(tailAwait),
 	)
 	if err != nil {
 		return 0, errors.Wrap(err, "get the oplog cursor")
@@ -64,7 +86,23 @@ func (ot *Oplog) WriteTo(w io.Writer) (int64, error) {
 	opts := primitive.Timestamp{}
 	var ok bool
 	var written int64
-	for cur.Next(ctx) {
+	for {
+		if !cur.Next(ctx) {
+			if err := cur.Err(); err != nil {
+				return written, errors.Wrap(err, "tail the oplog cursor")
+			}
+			if cur.ID() == 0 {
+				return written, errors.New("oplog cursor died before reaching the chunk boundary")
+			}
+			// nothing new arrived within this getMore's await window; only
+			// call the slice done once wall time has actually reached end,
+			// otherwise keep waiting for it
+			if time.Now().UTC().Unix() < int64(ot.end.T) {
+				continue
+			}
+			return written, nil
+		}
+
 		opts.T, opts.I, ok = cur.Current.Lookup("ts").TimestampOK()
 		if !ok {
 			return written, errors.Errorf("get the timestamp of record %v", cur.Current)
@@ -84,8 +122,107 @@ func (ot *Oplog) WriteTo(w io.Writer) (int64, error) {
 		}
 		written += int64(n)
 	}
+}
+
+// WriteChunks tails the oplog starting at `from` and keeps rolling the
+// stream into contiguous ChunkSpan-sized slices, uploading each to storage
+// under a `[startTS-endTS]`-named object and registering it in the PITR
+// chunk catalog as soon as it lands. It runs until ctx is cancelled. When
+// dek is non-nil, each chunk is sealed with crypto.NewEncryptor before it
+// reaches storage. rl, if non-nil, throttles the upload to storage so an
+// ongoing PITR tailer can't saturate the link.
+//
+// Chunks are always stored uncompressed: nothing in this package compresses
+// the stream, so recording anything else in OplogChunk.Compression would
+// make restore try to decompress raw bytes.
+func (ot *Oplog) WriteChunks(ctx context.Context, cn *pbm.PBM, stg storage.Storage, rsName string, from primitive.Timestamp, dek crypto.Sensitive, rl *ratelimit.Limiter) error {
+	start := from
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		end := primitive.Timestamp{T: start.T + uint32(ChunkSpan.Seconds()), I: 0}
+		ot.SetTailingSpan(start, end)
+
+		fname := pitrChunkName(rsName, start, end)
+		sum, chunks, err := sealedWrite(stg, fname, dek, rl, func(w io.Writer) error {
+			_, err := ot.WriteTo(ctx, w)
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, "tail oplog chunk")
+		}
+
+		err = cn.PITRAddChunk(pbm.OplogChunk{
+			RS:      rsName,
+			FName:   fname,
+			StartTS: start,
+			EndTS:   end,
+			SHA256:  sum,
+			Chunks:  chunks,
+		})
+		if err != nil {
+			return errors.Wrap(err, "register oplog chunk")
+		}
+
+		start = end
+	}
+}
+
+// sealedWrite streams whatever write sends into hw through rl (rate limit),
+// an optional crypto.Encryptor keyed by dek, and checksum hashing, uploading
+// the result to stg under name. It returns the whole-stream SHA-256 and its
+// per-chunk digests for the backup/chunk catalog, ready to verify against
+// later with backup.Check or checksum.NewVerifyReader. This is the one
+// place a backup artifact's bytes reach storage, shared by the PITR oplog
+// tailer (WriteChunks) and a replset's own dump/oplog backup.
+func sealedWrite(stg storage.Storage, name string, dek crypto.Sensitive, rl *ratelimit.Limiter, write func(w io.Writer) error) (string, []checksum.ChunkDigest, error) {
+	pr, pw := io.Pipe()
+	saveErr := make(chan error, 1)
+	go func() {
+		saveErr <- stg.Save(name, pr, -1)
+	}()
+
+	var w io.Writer = rl.WrapWriter(pw)
+	var enc *crypto.Encryptor
+	if dek != nil {
+		var err error
+		enc, err = crypto.NewEncryptor(w, dek)
+		if err != nil {
+			pw.CloseWithError(err)
+			<-saveErr
+			return "", nil, errors.Wrap(err, "init encryptor")
+		}
+		w = enc
+	}
+	hw := checksum.NewHashWriter(w)
+
+	err := write(hw)
+	if err == nil && enc != nil {
+		err = enc.Close()
+	}
+	pw.CloseWithError(err)
+	if err != nil {
+		<-saveErr
+		return "", nil, err
+	}
+	if err := <-saveErr; err != nil {
+		return "", nil, errors.Wrapf(err, "save %s", name)
+	}
+
+	sum, chunks := hw.Sum()
+	return sum, chunks, nil
+}
+
+func pitrChunkName(rs string, start, end primitive.Timestamp) string {
+	return rs + "/" + formatTS(start) + "-" + formatTS(end) + ".oplog"
+}
 
-	return written, cur.Err()
+func formatTS(ts primitive.Timestamp) string {
+	return strconv.FormatUint(uint64(ts.T), 10) + "," + strconv.FormatUint(uint64(ts.I), 10)
 }
 
 var errMongoTimestampNil = errors.New("timestamp is nil")
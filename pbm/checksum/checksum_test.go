@@ -0,0 +1,106 @@
+package checksum
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestHashWriterVerifyReaderRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("oplog chunk data"), 1000)
+
+	var sealed bytes.Buffer
+	hw := NewHashWriter(&sealed)
+	if _, err := hw.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	sum, chunks := hw.Sum()
+	if sum == "" {
+		t.Fatal("expected a non-empty whole-stream digest")
+	}
+	if len(chunks) != 1 {
+		t.Fatalf("expected a single short final chunk, got %d", len(chunks))
+	}
+
+	vr := NewVerifyReader(bytes.NewReader(sealed.Bytes()), chunks)
+	got, err := io.ReadAll(vr)
+	if err != nil {
+		t.Fatalf("verify read: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("verified stream doesn't match the original data")
+	}
+}
+
+func TestHashWriterRollsMultipleChunks(t *testing.T) {
+	data := make([]byte, ChunkSize+1024)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	var sealed bytes.Buffer
+	hw := NewHashWriter(&sealed)
+	if _, err := hw.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_, chunks := hw.Sum()
+	if len(chunks) != 2 {
+		t.Fatalf("expected 2 chunks for %d bytes, got %d", len(data), len(chunks))
+	}
+	if chunks[0].Offset != 0 {
+		t.Fatalf("expected first chunk offset 0, got %d", chunks[0].Offset)
+	}
+	if chunks[1].Offset != ChunkSize {
+		t.Fatalf("expected second chunk offset %d, got %d", ChunkSize, chunks[1].Offset)
+	}
+}
+
+func TestVerifyReaderDetectsCorruption(t *testing.T) {
+	data := bytes.Repeat([]byte("data"), 1000)
+
+	var sealed bytes.Buffer
+	hw := NewHashWriter(&sealed)
+	if _, err := hw.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_, chunks := hw.Sum()
+
+	corrupted := append([]byte(nil), sealed.Bytes()...)
+	corrupted[0] ^= 0xFF
+
+	vr := NewVerifyReader(bytes.NewReader(corrupted), chunks)
+	if _, err := io.ReadAll(vr); err == nil {
+		t.Fatal("expected a checksum mismatch error, got nil")
+	}
+}
+
+func TestVerifyReaderDetectsTruncation(t *testing.T) {
+	data := bytes.Repeat([]byte("data"), 1000)
+
+	var sealed bytes.Buffer
+	hw := NewHashWriter(&sealed)
+	if _, err := hw.Write(data); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	_, chunks := hw.Sum()
+
+	truncated := sealed.Bytes()[:len(sealed.Bytes())/2]
+
+	vr := NewVerifyReader(bytes.NewReader(truncated), chunks)
+	if _, err := io.ReadAll(vr); err == nil {
+		t.Fatal("expected a checksum mismatch error on the short final chunk, got nil")
+	}
+}
+
+func TestNewVerifyReaderNilExpectedDisablesVerification(t *testing.T) {
+	data := bytes.Repeat([]byte("data"), 1000)
+
+	vr := NewVerifyReader(bytes.NewReader(data), nil)
+	got, err := io.ReadAll(vr)
+	if err != nil {
+		t.Fatalf("expected no error with nil expected digests, got %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("data read through a disabled VerifyReader should be unchanged")
+	}
+}
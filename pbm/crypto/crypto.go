@@ -0,0 +1,211 @@
+// Package crypto provides client-side encryption of backup artifacts
+// (mongodump and oplog streams) before they reach storage.Storage.
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// FrameSize is the size, in bytes, of a plaintext chunk before it is sealed
+// into a frame. Framing the stream lets a reader fail fast on a corrupt
+// frame instead of only detecting tampering at EOF.
+const FrameSize = 64 * 1024
+
+// AlgorithmAES256GCM identifies this package's framing in BackupEncryption.Algorithm
+const AlgorithmAES256GCM = "aes-256-gcm"
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+	// each frame is prefixed with its sealed length so Decryptor knows how
+	// many bytes to read before calling Open
+	lenPrefixSize = 4
+)
+
+// Encryptor wraps an io.Writer, sealing everything written to it with
+// AES-256-GCM in FrameSize chunks. The nonce for frame N is the stream's
+// random file-nonce XORed with the big-endian frame counter, so no nonce
+// is ever reused for a given DEK.
+type Encryptor struct {
+	w         io.Writer
+	aead      cipher.AEAD
+	fileNonce [nonceSize]byte
+	counter   uint64
+	buf       []byte
+	pos       int
+}
+
+// NewEncryptor creates an Encryptor writing sealed frames to w, using key as
+// the AES-256-GCM data encryption key (DEK). key must be 32 bytes.
+func NewEncryptor(w io.Writer, key []byte) (*Encryptor, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Encryptor{w: w, aead: aead, buf: make([]byte, FrameSize)}
+	if _, err := rand.Read(e.fileNonce[:]); err != nil {
+		return nil, errors.Wrap(err, "generate file nonce")
+	}
+	if err := writeFileNonce(w, e.fileNonce[:]); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Write buffers p and seals it into FrameSize frames as they fill up.
+func (e *Encryptor) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(e.buf[e.pos:], p)
+		e.pos += n
+		p = p[n:]
+		written += n
+		if e.pos == len(e.buf) {
+			if err := e.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close seals any buffered remainder as the final (possibly short) frame.
+func (e *Encryptor) Close() error {
+	if e.pos == 0 {
+		return nil
+	}
+	return e.flush()
+}
+
+func (e *Encryptor) flush() error {
+	sealed := e.aead.Seal(nil, e.frameNonce(), e.buf[:e.pos], nil)
+	e.counter++
+	e.pos = 0
+	return writeFrame(e.w, sealed)
+}
+
+func (e *Encryptor) frameNonce() []byte {
+	return xorNonceCounter(e.fileNonce, e.counter)
+}
+
+// Decryptor wraps an io.Reader, unsealing frames written by an Encryptor
+// with the same key. Unsealing a frame fails fast on any tampering, or on
+// truncation inside a frame, rather than silently returning corrupt
+// plaintext. A stream cut off cleanly between two frames is not detected:
+// it reads as a shorter stream ending in io.EOF.
+type Decryptor struct {
+	r         io.Reader
+	aead      cipher.AEAD
+	fileNonce [nonceSize]byte
+	counter   uint64
+	pending   []byte
+}
+
+// NewDecryptor creates a Decryptor reading sealed frames from r.
+func NewDecryptor(r io.Reader, key []byte) (*Decryptor, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Decryptor{r: r, aead: aead}
+	if _, err := io.ReadFull(r, d.fileNonce[:]); err != nil {
+		return nil, errors.Wrap(err, "read file nonce")
+	}
+	return d, nil
+}
+
+// Read unseals frames as needed to satisfy p.
+func (d *Decryptor) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		frame, err := readFrame(d.r)
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "read frame")
+		}
+
+		plain, err := d.aead.Open(nil, xorNonceCounter(d.fileNonce, d.counter), frame, nil)
+		if err != nil {
+			return 0, errors.Wrapf(err, "decrypt frame %d: auth tag mismatch, stream is corrupt or tampered", d.counter)
+		}
+		d.counter++
+		d.pending = plain
+	}
+
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.Errorf("AES-256-GCM requires a 32-byte key, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "init AES cipher")
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, nonceSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "init GCM")
+	}
+	return aead, nil
+}
+
+func xorNonceCounter(fileNonce [nonceSize]byte, counter uint64) []byte {
+	n := make([]byte, nonceSize)
+	copy(n, fileNonce[:])
+	var cb [8]byte
+	binary.BigEndian.PutUint64(cb[:], counter)
+	for i := 0; i < 8; i++ {
+		n[nonceSize-8+i] ^= cb[i]
+	}
+	return n
+}
+
+func writeFileNonce(w io.Writer, nonce []byte) error {
+	_, err := w.Write(nonce)
+	return errors.Wrap(err, "write file nonce")
+}
+
+func writeFrame(w io.Writer, sealed []byte) error {
+	var lb [lenPrefixSize]byte
+	binary.BigEndian.PutUint32(lb[:], uint32(len(sealed)))
+	if _, err := w.Write(lb[:]); err != nil {
+		return errors.Wrap(err, "write frame length")
+	}
+	if _, err := w.Write(sealed); err != nil {
+		return errors.Wrap(err, "write frame")
+	}
+	return nil
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var lb [lenPrefixSize]byte
+	if _, err := io.ReadFull(r, lb[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated stream: partial frame length")
+		}
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(lb[:])
+	if n > FrameSize+tagSize {
+		return nil, errors.Errorf("frame length %d exceeds max sealed frame size", n)
+	}
+
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, errors.Wrap(err, "truncated stream: partial frame")
+	}
+	return frame, nil
+}
@@ -0,0 +1,102 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func testKey() []byte {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	plain := bytes.Repeat([]byte("percona-backup-mongodb"), FrameSize/4)
+
+	var sealed bytes.Buffer
+	enc, err := NewEncryptor(&sealed, testKey())
+	if err != nil {
+		t.Fatalf("NewEncryptor: %v", err)
+	}
+	if _, err := enc.Write(plain); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	dec, err := NewDecryptor(&sealed, testKey())
+	if err != nil {
+		t.Fatalf("NewDecryptor: %v", err)
+	}
+	got, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatalf("read decrypted stream: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("round trip mismatch: got %d bytes, want %d bytes", len(got), len(plain))
+	}
+}
+
+func TestEncryptDecryptShortFinalFrame(t *testing.T) {
+	plain := []byte("short trailing frame, well under FrameSize")
+
+	var sealed bytes.Buffer
+	enc, err := NewEncryptor(&sealed, testKey())
+	if err != nil {
+		t.Fatalf("NewEncryptor: %v", err)
+	}
+	if _, err := enc.Write(plain); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	dec, err := NewDecryptor(&sealed, testKey())
+	if err != nil {
+		t.Fatalf("NewDecryptor: %v", err)
+	}
+	got, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatalf("read decrypted stream: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("round trip mismatch: got %q, want %q", got, plain)
+	}
+}
+
+func TestDecryptDetectsTamperedFrame(t *testing.T) {
+	var sealed bytes.Buffer
+	enc, err := NewEncryptor(&sealed, testKey())
+	if err != nil {
+		t.Fatalf("NewEncryptor: %v", err)
+	}
+	if _, err := enc.Write([]byte("data an attacker flips a bit in")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	tampered := sealed.Bytes()
+	tampered[len(tampered)-1] ^= 0xFF
+
+	dec, err := NewDecryptor(bytes.NewReader(tampered), testKey())
+	if err != nil {
+		t.Fatalf("NewDecryptor: %v", err)
+	}
+	if _, err := io.ReadAll(dec); err == nil {
+		t.Fatal("expected an auth tag mismatch error, got nil")
+	}
+}
+
+func TestNewAEADRejectsWrongKeySize(t *testing.T) {
+	if _, err := NewEncryptor(&bytes.Buffer{}, make([]byte, 16)); err == nil {
+		t.Fatal("expected an error for a non-32-byte key, got nil")
+	}
+}
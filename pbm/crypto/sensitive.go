@@ -0,0 +1,19 @@
+package crypto
+
+// Sensitive holds key material. It implements fmt.Stringer and
+// json.Marshaler so that accidentally logging, Printf-ing, or JSON-encoding
+// a value of this type prints "***" instead of the real bytes, mirroring
+// the pattern dgraph uses for its Sensitive string type. BSON marshaling is
+// not overridden: encoding it to BSON writes the real bytes.
+type Sensitive []byte
+
+// String never reveals the wrapped bytes.
+func (Sensitive) String() string {
+	return "***"
+}
+
+// MarshalJSON redacts the key so it can't leak through a struct that embeds
+// a Sensitive field and gets logged or written to a config file as JSON.
+func (Sensitive) MarshalJSON() ([]byte, error) {
+	return []byte(`"***"`), nil
+}
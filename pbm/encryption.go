@@ -0,0 +1,11 @@
+package pbm
+
+// BackupEncryption records how a backup's dump and oplog streams were
+// sealed, so restore can pick the right KeySource and unwrap the data
+// encryption key (DEK) that was used at backup time. The zero value means
+// the backup is stored unencrypted.
+type BackupEncryption struct {
+	Algorithm  string `bson:"algorithm,omitempty" json:"algorithm,omitempty"`
+	FrameSize  int    `bson:"frame_size,omitempty" json:"frame_size,omitempty"`
+	WrappedDEK []byte `bson:"wrapped_dek,omitempty" json:"wrapped_dek,omitempty"`
+}
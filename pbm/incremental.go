@@ -0,0 +1,11 @@
+package pbm
+
+// BackupType distinguishes a full backup (a mongodump plus its covering
+// oplog slice) from an incremental one (oplog slices only, anchored to a
+// prior backup's cluster time).
+type BackupType string
+
+const (
+	BackupTypeFull        BackupType = "full"
+	BackupTypeIncremental BackupType = "incremental"
+)
@@ -0,0 +1,19 @@
+package pbm
+
+import "github.com/sbstp/percona-backup-mongodb/pbm/crypto"
+
+// keySource holds this agent's configured backup encryption key source, set
+// once at startup from the PBM config (a local key file, an env var, or a
+// KMS-style remote). It's nil when encryption isn't configured.
+var keySource crypto.KeySource
+
+// SetKeySource configures the key source used to wrap/unwrap backup DEKs
+func SetKeySource(ks crypto.KeySource) {
+	keySource = ks
+}
+
+// KeySource returns the agent's configured encryption key source, or nil if
+// backups aren't encrypted.
+func (p *PBM) KeySource() crypto.KeySource {
+	return keySource
+}
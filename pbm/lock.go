@@ -0,0 +1,36 @@
+package pbm
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LockHeartbeat bumps the Heartbeat field of this agent's own lock document
+// (identified by lh) to the current cluster time, keeping converged/
+// waitForStatus on other nodes from declaring it stale. It returns
+// mongo.ErrNoDocuments if the lock has already been removed, e.g. because
+// another node cleaned it up after declaring this one lost.
+func (p *PBM) LockHeartbeat(lh LockHeader) error {
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "read cluster time")
+	}
+
+	res, err := p.Conn.Database(DB).Collection(LockCollection).UpdateOne(
+		p.Context(),
+		bson.M{
+			"type":    lh.Type,
+			"backup":  lh.BackupName,
+			"replset": lh.Replset,
+		},
+		bson.M{"$set": bson.M{"heartbeat": ct}},
+	)
+	if err != nil {
+		return errors.Wrap(err, "update lock heartbeat")
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Package lock keeps an agent's own PBM lock alive for the duration of a
+// long-running operation (currently restore) and cancels a derived context
+// the moment that's no longer safe, so in-flight work gets torn down
+// instead of running on after everyone else has given up.
+package lock
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sbstp/percona-backup-mongodb/pbm"
+)
+
+// DefaultInterval is how often Refresher bumps its lock's heartbeat. It
+// should be comfortably smaller than pbm.StaleFrameSec so a transient
+// hiccup doesn't get the lock declared stale.
+const DefaultInterval = 5 * time.Second
+
+// Refresher periodically bumps the heartbeat on one agent's own lock.
+type Refresher struct {
+	cn       *pbm.PBM
+	lh       pbm.LockHeader
+	interval time.Duration
+}
+
+// New creates a Refresher for the lock identified by lh.
+func New(cn *pbm.PBM, lh pbm.LockHeader) *Refresher {
+	return &Refresher{cn: cn, lh: lh, interval: DefaultInterval}
+}
+
+// Run starts heartbeating the lock in the background and returns a context
+// derived from parent that's cancelled as soon as either:
+//   - this agent's own lock disappears or can't be refreshed,
+//   - abandoned(), e.g. checking whether the leader marked the restore
+//     StatusError, reports true, or
+//   - the caller invokes the returned CancelFunc.
+//
+// The background goroutine exits once the returned context is done, so
+// callers must call the returned CancelFunc (typically via defer) once
+// they're done with the operation the lock guards, or it leaks for the
+// lifetime of parent.
+func (rf *Refresher) Run(parent context.Context, abandoned func() (bool, error)) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	go func() {
+		tk := time.NewTicker(rf.interval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tk.C:
+				if err := rf.cn.LockHeartbeat(rf.lh); err != nil {
+					log.Printf("[ERROR] lock refresher: lost lock for %s/%s, cancelling: %v\n", rf.lh.BackupName, rf.lh.Replset, err)
+					cancel()
+					return
+				}
+
+				stop, err := abandoned()
+				if err != nil {
+					log.Println("[ERROR] lock refresher: check abandoned:", err)
+					continue
+				}
+				if stop {
+					log.Printf("[ERROR] lock refresher: restore %s was abandoned, cancelling\n", rf.lh.BackupName)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return ctx, cancel
+}
@@ -68,4 +68,18 @@ func (n *Node) Status() (*NodeStatus, error) {
 
 func (n *Node) ConnURI() string {
 	return n.opts
-}
\ No newline at end of file
+}
+
+// DBHash runs the dbHash command against db and returns its combined hash,
+// used to detect silent restore corruption by comparing against a hash
+// captured for the same DB at backup time.
+func (n *Node) DBHash(db string) (string, error) {
+	r := struct {
+		MD5 string `bson:"md5"`
+	}{}
+	err := n.cn.Database(db).RunCommand(nil, bson.D{{"dbHash", 1}}).Decode(&r)
+	if err != nil {
+		return "", errors.Wrap(err, "run mongo command dbHash")
+	}
+	return r.MD5, nil
+}
@@ -0,0 +1,58 @@
+package pbm
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/sbstp/percona-backup-mongodb/pbm/checksum"
+)
+
+// PITRChunksCollection stores the catalog of oplog slices ("chunks") produced
+// by the agents' oplog-tailer. Restore uses it to resolve a chain of slices
+// covering an arbitrary point-in-time target.
+const PITRChunksCollection = "pbmPITRChunks"
+
+// OplogChunk describes one contiguous oplog slice persisted to storage.
+// Chunks for a given replset chain together: a chunk's StartTS should equal
+// the previous chunk's EndTS with no gap in between.
+type OplogChunk struct {
+	RS          string                 `bson:"rs" json:"rs"`
+	FName       string                 `bson:"fname" json:"fname"`
+	Compression string                 `bson:"compression" json:"compression"`
+	StartTS     primitive.Timestamp    `bson:"start_ts" json:"start_ts"`
+	EndTS       primitive.Timestamp    `bson:"end_ts" json:"end_ts"`
+	SHA256      string                 `bson:"sha256,omitempty" json:"sha256,omitempty"`
+	Chunks      []checksum.ChunkDigest `bson:"chunks,omitempty" json:"chunks,omitempty"`
+}
+
+// PITRAddChunk registers a finished oplog chunk in the PBM control DB
+func (p *PBM) PITRAddChunk(c OplogChunk) error {
+	_, err := p.Conn.Database(DB).Collection(PITRChunksCollection).InsertOne(p.Context(), c)
+	return errors.Wrap(err, "insert oplog chunk meta")
+}
+
+// PITRGetChunksSlice returns the chunks of replset rs sorted by StartTS that
+// overlap with [from, to]
+func (p *PBM) PITRGetChunksSlice(rs string, from, to primitive.Timestamp) ([]OplogChunk, error) {
+	cur, err := p.Conn.Database(DB).Collection(PITRChunksCollection).Find(
+		p.Context(),
+		bson.M{
+			"rs":       rs,
+			"start_ts": bson.M{"$lte": to},
+			"end_ts":   bson.M{"$gte": from},
+		},
+		options.Find().SetSort(bson.D{{"start_ts", 1}}),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "query chunks")
+	}
+	defer cur.Close(p.Context())
+
+	chunks := []OplogChunk{}
+	if err := cur.All(p.Context(), &chunks); err != nil {
+		return nil, errors.Wrap(err, "decode chunks")
+	}
+	return chunks, nil
+}
@@ -0,0 +1,126 @@
+// Package ratelimit throttles backup/restore I/O so a backup or restore
+// doesn't saturate the link to storage or between agents.
+package ratelimit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a simple token bucket: tokens (bytes) accrue at bytesPerSec and
+// are spent by WrapWriter/WrapReader before letting the underlying I/O
+// through. A nil *Limiter is a valid, unlimited limiter, so call sites don't
+// need to special-case "no rate limit configured".
+type Limiter struct {
+	bytesPerSec float64
+	burst       float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Effective picks the rate limit to enforce: an explicit per-command value
+// wins over the agent's global cap from the PBM config; 0 on both means
+// unlimited.
+func Effective(cmdMBs, globalMBs float64) *Limiter {
+	if cmdMBs > 0 {
+		return New(cmdMBs)
+	}
+	return New(globalMBs)
+}
+
+// New returns a Limiter capped at mbPerSec megabytes/second. A mbPerSec <= 0
+// means unlimited, represented by a nil *Limiter.
+func New(mbPerSec float64) *Limiter {
+	if mbPerSec <= 0 {
+		return nil
+	}
+	bps := mbPerSec * 1024 * 1024
+	return &Limiter{
+		bytesPerSec: bps,
+		burst:       bps, // allow bursting up to one second's worth
+		tokens:      bps,
+		last:        time.Now(),
+	}
+}
+
+// wait blocks until n bytes' worth of tokens are available and spends them.
+// The sleep for a token deficit happens outside the lock; a deficit is kept
+// as debt on the bucket, so concurrent streams sharing this Limiter also
+// wait out each other's outstanding debt and together stay within its rate.
+func (l *Limiter) wait(n int) {
+	if l == nil {
+		return
+	}
+
+	sleep := l.spend(n)
+	if sleep > 0 {
+		time.Sleep(sleep)
+	}
+}
+
+// spend accrues tokens up to now, deducts n, and reports how long the
+// caller must sleep to cover any deficit.
+func (l *Limiter) spend(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.bytesPerSec
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	// leave the deficit as negative tokens: the time slept accrues them
+	// back, so it isn't credited a second time on the next call
+	return time.Duration(-l.tokens / l.bytesPerSec * float64(time.Second))
+}
+
+// WrapWriter returns w throttled to this Limiter's rate. A nil Limiter
+// returns w unchanged.
+func (l *Limiter) WrapWriter(w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &limitedWriter{w: w, l: l}
+}
+
+// WrapReader returns r throttled to this Limiter's rate. A nil Limiter
+// returns r unchanged.
+func (l *Limiter) WrapReader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, l: l}
+}
+
+type limitedWriter struct {
+	w io.Writer
+	l *Limiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	lw.l.wait(len(p))
+	return lw.w.Write(p)
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *Limiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		lr.l.wait(n)
+	}
+	return n, err
+}
@@ -0,0 +1,28 @@
+package restore
+
+import (
+	"context"
+	"io"
+)
+
+// ctxReader checks ctx before every Read and returns ctx.Err() once it's
+// done, so a stream consumer (mongorestore, the oplog applier) stops on its
+// next Read after cancellation. A Read already blocked in the underlying
+// reader is not interrupted.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func withCancel(ctx context.Context, r io.Reader) io.Reader {
+	return &ctxReader{ctx: ctx, r: r}
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	select {
+	case <-c.ctx.Done():
+		return 0, c.ctx.Err()
+	default:
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,29 @@
+package restore
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/sbstp/percona-backup-mongodb/pbm"
+)
+
+// ChecksumModeAfter is the RestoreCmd.ChecksumMode value that triggers a
+// post-restore dbHash comparison against the hashes captured at backup
+// time, catching silent restore corruption that a stream checksum (taken
+// before mongorestore/oplog-apply touch the data) wouldn't.
+const ChecksumModeAfter = "after"
+
+// verifyDBHashes re-runs dbHash on every DB this replset restored and
+// compares it against the hash recorded in rsBackup.DBHashes at backup
+// time, failing with the name of the first DB whose hash doesn't match.
+func (r *Restore) verifyDBHashes(rsBackup pbm.BackupReplset) error {
+	for db, want := range rsBackup.DBHashes {
+		got, err := r.node.DBHash(db)
+		if err != nil {
+			return errors.Wrapf(err, "compute dbHash for %s", db)
+		}
+		if got != want {
+			return errors.Errorf("dbHash mismatch for %s: expected %s, got %s", db, want, got)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package restore
+
+import (
+	"io"
+
+	"github.com/sbstp/percona-backup-mongodb/pbm/checksum"
+)
+
+// verifiedReader pairs a checksum.VerifyReader with the underlying
+// io.ReadCloser it wraps, so callers can keep treating it as the same
+// closeable stream while every byte is checked against the digests
+// recorded at backup time.
+type verifiedReader struct {
+	*checksum.VerifyReader
+	underlying io.Closer
+}
+
+func verifyingReader(src io.ReadCloser, expected []checksum.ChunkDigest) io.ReadCloser {
+	return &verifiedReader{
+		VerifyReader: checksum.NewVerifyReader(src, expected),
+		underlying:   src,
+	}
+}
+
+func (v *verifiedReader) Close() error {
+	return v.underlying.Close()
+}
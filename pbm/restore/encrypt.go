@@ -0,0 +1,36 @@
+package restore
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/sbstp/percona-backup-mongodb/pbm"
+	"github.com/sbstp/percona-backup-mongodb/pbm/crypto"
+)
+
+// maybeDecrypt wraps src in a crypto.Decryptor when enc describes an
+// encrypted stream, unwrapping the DEK via the restore's configured
+// KeySource. A zero-value enc (no algorithm set) is a no-op, so restoring
+// an unencrypted backup is unaffected.
+func (r *Restore) maybeDecrypt(src io.Reader, enc pbm.BackupEncryption) (io.Reader, error) {
+	if enc.Algorithm == "" {
+		return src, nil
+	}
+	if enc.Algorithm != crypto.AlgorithmAES256GCM {
+		return nil, errors.Errorf("unsupported encryption algorithm %q", enc.Algorithm)
+	}
+
+	ks := r.cn.KeySource()
+	if ks == nil {
+		return nil, errors.New("backup is encrypted but no key source is configured")
+	}
+
+	dek, err := ks.UnwrapDEK(enc.WrappedDEK)
+	if err != nil {
+		return nil, errors.Wrap(err, "unwrap data encryption key")
+	}
+
+	dec, err := crypto.NewDecryptor(src, dek)
+	return dec, errors.Wrap(err, "init decryptor")
+}
@@ -0,0 +1,91 @@
+package restore
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/sbstp/percona-backup-mongodb/pbm"
+	"github.com/sbstp/percona-backup-mongodb/pbm/storage"
+)
+
+// resolveBackupChain walks bcp's BaseBackup pointers back to the full
+// backup it ultimately depends on, returning the chain ordered oldest
+// (the full backup) first. It fails on a broken chain (a BaseBackup that
+// can't be found, a cycle, or a base whose coverage doesn't end exactly
+// where the incremental on top of it starts) rather than silently
+// restoring with a gap, the same guarantee planPITRChain makes for the
+// oplog-chunk catalog.
+func (r *Restore) resolveBackupChain(bcp *pbm.BackupMeta, stg storage.Storage) ([]*pbm.BackupMeta, error) {
+	chain := []*pbm.BackupMeta{bcp}
+	seen := map[string]bool{bcp.Name: true}
+
+	cur := bcp
+	for cur.Type == pbm.BackupTypeIncremental {
+		if cur.BaseBackup == "" {
+			return nil, errors.Errorf("incremental backup %s has no base backup recorded", cur.Name)
+		}
+		if seen[cur.BaseBackup] {
+			return nil, errors.Errorf("cycle in backup chain: %s already appears before %s", cur.BaseBackup, cur.Name)
+		}
+
+		base, err := r.cn.GetBackupMeta(cur.BaseBackup)
+		if errors.Cause(err) == mongo.ErrNoDocuments {
+			base, err = getMetaFromStore(cur.BaseBackup, stg)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "get base backup %s", cur.BaseBackup)
+		}
+
+		if err := checkChainContinuity(base, cur); err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, base)
+		seen[base.Name] = true
+		cur = base
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
+
+// checkChainContinuity verifies that, for every replset cur covers, base's
+// recorded coverage ends exactly where cur's begins. A stale or
+// misconfigured BaseBackup pointer would otherwise splice in a base whose
+// oplog doesn't actually connect to cur, restoring with a silent data gap.
+func checkChainContinuity(base, cur *pbm.BackupMeta) error {
+	for _, crs := range cur.Replsets {
+		brs, err := backupReplset(base, crs.Name)
+		if err != nil {
+			return errors.Wrapf(err, "backup %s: base %s has no metadata for replset %s", cur.Name, base.Name, crs.Name)
+		}
+		if primitive.CompareTimestamp(crs.StartTS, brs.LastWriteTS) != 0 {
+			return errors.Errorf(
+				"gap in backup chain for replset %s: %s starts at %v but base %s ends at %v",
+				crs.Name, cur.Name, crs.StartTS, base.Name, brs.LastWriteTS)
+		}
+	}
+	return nil
+}
+
+// oplogChunkSrc pairs an oplog chunk to replay with the Encryption of the
+// backup it was sealed under, so a chain of incrementals -- each with its
+// own DEK -- decrypts every chunk with the right key instead of whatever
+// the full backup at the root of the chain used.
+type oplogChunkSrc struct {
+	pbm.OplogChunk
+	Encryption pbm.BackupEncryption
+}
+
+// backupReplset returns the replset slice of b for rsName
+func backupReplset(b *pbm.BackupMeta, rsName string) (pbm.BackupReplset, error) {
+	for _, v := range b.Replsets {
+		if v.Name == rsName {
+			return v, nil
+		}
+	}
+	return pbm.BackupReplset{}, errors.Errorf("metadata for replset/shard %s is not found in backup %s", rsName, b.Name)
+}
@@ -0,0 +1,70 @@
+package restore
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/sbstp/percona-backup-mongodb/pbm"
+)
+
+func TestCheckChainContinuityAcceptsContiguousChain(t *testing.T) {
+	base := &pbm.BackupMeta{
+		Name: "base",
+		Replsets: []pbm.BackupReplset{
+			{Name: "rs0", LastWriteTS: primitive.Timestamp{T: 100}},
+		},
+	}
+	cur := &pbm.BackupMeta{
+		Name: "incr",
+		Replsets: []pbm.BackupReplset{
+			{Name: "rs0", StartTS: primitive.Timestamp{T: 100}},
+		},
+	}
+
+	if err := checkChainContinuity(base, cur); err != nil {
+		t.Fatalf("expected a contiguous chain to pass, got: %v", err)
+	}
+}
+
+func TestCheckChainContinuityRejectsGap(t *testing.T) {
+	base := &pbm.BackupMeta{
+		Name: "base",
+		Replsets: []pbm.BackupReplset{
+			{Name: "rs0", LastWriteTS: primitive.Timestamp{T: 100}},
+		},
+	}
+	cur := &pbm.BackupMeta{
+		Name: "incr",
+		Replsets: []pbm.BackupReplset{
+			{Name: "rs0", StartTS: primitive.Timestamp{T: 150}},
+		},
+	}
+
+	if err := checkChainContinuity(base, cur); err == nil {
+		t.Fatal("expected a gap between base's coverage and cur's start to be rejected, got nil")
+	}
+}
+
+func TestCheckChainContinuityRejectsMissingReplset(t *testing.T) {
+	base := &pbm.BackupMeta{
+		Name:     "base",
+		Replsets: []pbm.BackupReplset{{Name: "rs0", LastWriteTS: primitive.Timestamp{T: 100}}},
+	}
+	cur := &pbm.BackupMeta{
+		Name:     "incr",
+		Replsets: []pbm.BackupReplset{{Name: "rs1", StartTS: primitive.Timestamp{T: 100}}},
+	}
+
+	if err := checkChainContinuity(base, cur); err == nil {
+		t.Fatal("expected a replset missing from the base backup to be rejected, got nil")
+	}
+}
+
+func TestBackupReplsetNotFound(t *testing.T) {
+	b := &pbm.BackupMeta{Name: "base", Replsets: []pbm.BackupReplset{{Name: "rs0"}}}
+
+	if _, err := backupReplset(b, "rs1"); err == nil {
+		t.Fatal("expected an error for a replset not present in the backup, got nil")
+	}
+}
@@ -0,0 +1,89 @@
+package restore
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/sbstp/percona-backup-mongodb/pbm"
+)
+
+// planPITRChain resolves the chain of oplog chunks needed to replay replset
+// rs from the end of the chosen backup up to targetTS. It fails if the
+// catalog has a gap in coverage or targetTS is older than the backup itself.
+func (r *Restore) planPITRChain(rsBackup pbm.BackupReplset, targetTS primitive.Timestamp) ([]pbm.OplogChunk, error) {
+	if primitive.CompareTimestamp(targetTS, rsBackup.LastWriteTS) < 0 {
+		return nil, errors.Errorf("target time %v is before the backup's cluster time %v", targetTS, rsBackup.LastWriteTS)
+	}
+
+	chunks, err := r.cn.PITRGetChunksSlice(rsBackup.Name, rsBackup.LastWriteTS, targetTS)
+	if err != nil {
+		return nil, errors.Wrap(err, "get oplog chunks")
+	}
+	if len(chunks) == 0 {
+		return nil, errors.Errorf("no oplog chunks cover %v for replset %s", targetTS, rsBackup.Name)
+	}
+
+	last := rsBackup.LastWriteTS
+	for i, c := range chunks {
+		if primitive.CompareTimestamp(c.StartTS, last) != 0 {
+			return nil, errors.Errorf("gap in oplog chunk chain for replset %s: expected chunk starting at %v, got %v", rsBackup.Name, last, c.StartTS)
+		}
+		last = c.EndTS
+		if primitive.CompareTimestamp(last, targetTS) >= 0 {
+			chunks = chunks[:i+1]
+			break
+		}
+	}
+
+	if primitive.CompareTimestamp(last, targetTS) < 0 {
+		return nil, errors.Errorf("oplog chunk coverage for replset %s ends at %v, before the target %v", rsBackup.Name, last, targetTS)
+	}
+
+	return chunks, nil
+}
+
+// boundedOplogReader wraps a decompressed oplog stream and stops yielding
+// bytes at the first record whose `ts` exceeds targetTS, surfacing io.EOF
+// from then on so the applier never replays past the requested point in time.
+type boundedOplogReader struct {
+	src      *bufio.Reader
+	targetTS primitive.Timestamp
+	pending  bytes.Buffer
+	done     bool
+}
+
+func newBoundedOplogReader(src io.Reader, targetTS primitive.Timestamp) *boundedOplogReader {
+	return &boundedOplogReader{src: bufio.NewReader(src), targetTS: targetTS}
+}
+
+func (b *boundedOplogReader) Read(p []byte) (int, error) {
+	for b.pending.Len() == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+
+		raw, err := bson.NewFromIOReader(b.src)
+		if err == io.EOF {
+			b.done = true
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "read oplog record")
+		}
+
+		if ts, i, ok := raw.Lookup("ts").TimestampOK(); ok &&
+			primitive.CompareTimestamp(primitive.Timestamp{T: ts, I: i}, b.targetTS) > 0 {
+			b.done = true
+			return 0, io.EOF
+		}
+
+		b.pending.Write(raw)
+	}
+
+	return b.pending.Read(p)
+}
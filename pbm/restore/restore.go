@@ -3,7 +3,9 @@ package restore
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"log"
+	"runtime"
 	"strings"
 	"time"
 
@@ -15,6 +17,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/sbstp/percona-backup-mongodb/pbm"
+	"github.com/sbstp/percona-backup-mongodb/pbm/checksum"
+	"github.com/sbstp/percona-backup-mongodb/pbm/lock"
+	"github.com/sbstp/percona-backup-mongodb/pbm/ratelimit"
 	"github.com/sbstp/percona-backup-mongodb/pbm/storage"
 )
 
@@ -69,6 +74,22 @@ func (r *Restore) Run(cmd pbm.RestoreCmd) (err error) {
 		return errors.Errorf("backup wasn't successful: status: %s, error: %s", bcp.Status, bcp.Error)
 	}
 
+	// dbHashes are only recorded at a backup's own LastWriteTS, so there's
+	// nothing to compare a restore to an arbitrary TargetTS against
+	if cmd.ChecksumMode == ChecksumModeAfter && cmd.TargetTS.T != 0 {
+		return errors.New("post-restore checksum can't be combined with a point-in-time target")
+	}
+
+	chain := []*pbm.BackupMeta{bcp}
+	if bcp.Type == pbm.BackupTypeIncremental {
+		chain, err = r.resolveBackupChain(bcp, stg)
+		if err != nil {
+			return errors.Wrap(err, "resolve incremental backup chain")
+		}
+		log.Printf("restoring incremental chain of %d backup(s) ending at %s", len(chain), bcp.Name)
+	}
+	fullBcp := chain[0]
+
 	im, err := r.node.GetIsMaster()
 	if err != nil {
 		return errors.Wrap(err, "get isMaster data")
@@ -79,18 +100,9 @@ func (r *Restore) Run(cmd pbm.RestoreCmd) (err error) {
 		rsName = pbm.NoReplset
 	}
 
-	var (
-		rsBackup pbm.BackupReplset
-		ok       bool
-	)
-	for _, v := range bcp.Replsets {
-		if v.Name == rsName {
-			rsBackup = v
-			ok = true
-		}
-	}
-	if !ok {
-		return errors.Errorf("metadata for replset/shard %s is not found", rsName)
+	rsBackup, err := backupReplset(fullBcp, rsName)
+	if err != nil {
+		return err
 	}
 
 	meta := &pbm.RestoreMeta{
@@ -166,16 +178,37 @@ func (r *Restore) Run(cmd pbm.RestoreCmd) (err error) {
 		return errors.Wrap(err, "waiting for start")
 	}
 
+	lh := pbm.LockHeader{Type: pbm.CmdRestore, BackupName: cmd.Name, Replset: rsMeta.Name}
+	runCtx, stopRefresher := lock.New(r.cn, lh).Run(r.cn.Context(), func() (bool, error) {
+		m, err := r.cn.GetRestoreMeta(cmd.Name)
+		if err != nil {
+			return false, errors.Wrap(err, "get restore metadata")
+		}
+		return m.Status == pbm.StatusError, nil
+	})
+	defer stopRefresher()
+
 	sr, err := stg.SourceReader(rsBackup.DumpName)
 	if err != nil {
 		return errors.Wrapf(err, "get object %s for the storage", rsBackup.DumpName)
 	}
 	defer sr.Close()
 
-	dumpReader, err := Decompress(sr, bcp.Compression)
+	cfg, err := r.cn.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get pbm config")
+	}
+	rl := ratelimit.Effective(cmd.RateLimitMBs, cfg.Restore.RateLimitMBs)
+	dumpSrc, err := r.maybeDecrypt(rl.WrapReader(sr), fullBcp.Encryption)
+	if err != nil {
+		return errors.Wrapf(err, "decrypt object %s", rsBackup.DumpName)
+	}
+
+	dumpReader, err := Decompress(dumpSrc, fullBcp.Compression)
 	if err != nil {
 		return errors.Wrapf(err, "decompress object %s", rsBackup.DumpName)
 	}
+	dumpReader = verifyingReader(dumpReader, rsBackup.DumpChunks)
 	defer dumpReader.Close()
 
 	ver, err := r.node.GetMongoVersion()
@@ -185,6 +218,18 @@ func (r *Restore) Run(cmd pbm.RestoreCmd) (err error) {
 
 	preserveUUID := false
 
+	numInsertionWorkers := cmd.NumInsertionWorkers
+	if numInsertionWorkers <= 0 {
+		numInsertionWorkers = runtime.NumCPU()
+	}
+	numParallelCollections := cmd.NumParallelCollections
+	if numParallelCollections <= 0 {
+		numParallelCollections = runtime.NumCPU() / 2
+		if numParallelCollections < 1 {
+			numParallelCollections = 1
+		}
+	}
+
 	topts := options.ToolOptions{
 		AppName:    "mongodump",
 		VersionStr: "0.0.1",
@@ -199,6 +244,10 @@ func (r *Restore) Run(cmd pbm.RestoreCmd) (err error) {
 	if err != nil {
 		return errors.Wrap(err, "create session for the dump restore")
 	}
+	// thread the lock refresher's context through so an orphaned mongorestore
+	// worker gets torn down as soon as this node's lock is lost or the
+	// cluster abandons the restore, instead of running on unsupervised
+	rsession.SetContext(runCtx)
 
 	mr := mongorestore.MongoRestore{
 		SessionProvider: rsession,
@@ -210,8 +259,8 @@ func (r *Restore) Run(cmd pbm.RestoreCmd) (err error) {
 			BulkBufferSize:           100,
 			BypassDocumentValidation: true,
 			Drop:                     true,
-			NumInsertionWorkers:      2,
-			NumParallelCollections:   1,
+			NumInsertionWorkers:      numInsertionWorkers,
+			NumParallelCollections:   numParallelCollections,
 			PreserveUUID:             preserveUUID,
 			StopOnError:              true,
 			TempRolesColl:            "temproles",
@@ -223,7 +272,7 @@ func (r *Restore) Run(cmd pbm.RestoreCmd) (err error) {
 			NSFrom:    []string{`admin.system.users`, `admin.system.roles`},
 			NSTo:      []string{pbm.DB + `.` + tmpUsers, pbm.DB + `.` + tmpRoles},
 		},
-		InputReader: dumpReader,
+		InputReader: withCancel(runCtx, dumpReader),
 	}
 
 	rdumpResult := mr.Restore()
@@ -252,21 +301,76 @@ func (r *Restore) Run(cmd pbm.RestoreCmd) (err error) {
 
 	log.Println("starting the oplog replay")
 
-	or, err := stg.SourceReader(rsBackup.OplogName)
-	if err != nil {
-		return errors.Wrapf(err, "get object %s for the storage", rsBackup.DumpName)
+	// one oplog chunk per backup in the chain (just `bcp` itself for a full
+	// backup, or the full backup followed by every incremental up to `bcp`),
+	// each carrying the Encryption its owning backup was sealed with -- an
+	// incremental's DEK is its own, not the base full backup's
+	var oplogChunks []oplogChunkSrc
+	var tipRS pbm.BackupReplset
+	var tipEnc pbm.BackupEncryption
+	for _, b := range chain {
+		rs, err := backupReplset(b, rsName)
+		if err != nil {
+			return err
+		}
+		tipRS = rs
+		tipEnc = b.Encryption
+		oplogChunks = append(oplogChunks, oplogChunkSrc{
+			OplogChunk: pbm.OplogChunk{
+				FName:       rs.OplogName,
+				Compression: b.Compression,
+				Chunks:      rs.OplogChunks,
+				StartTS:     rs.StartTS,
+				EndTS:       rs.LastWriteTS,
+			},
+			Encryption: b.Encryption,
+		})
+	}
+
+	if cmd.TargetTS.T != 0 {
+		pitr, err := r.planPITRChain(tipRS, cmd.TargetTS)
+		if err != nil {
+			return errors.Wrap(err, "plan point-in-time restore chain")
+		}
+		// the continuous PITR tailer seals every chunk with whatever key was
+		// current for the chain's tip backup, not the (possibly much older)
+		// base full backup's
+		for _, c := range pitr {
+			oplogChunks = append(oplogChunks, oplogChunkSrc{OplogChunk: c, Encryption: tipEnc})
+		}
+		log.Printf("restoring to %v across %d oplog chunk(s)", cmd.TargetTS, len(oplogChunks))
 	}
-	defer or.Close()
 
-	oplogReader, err := Decompress(or, bcp.Compression)
-	if err != nil {
-		return errors.Wrapf(err, "decompress object %s", rsBackup.DumpName)
-	}
-	defer oplogReader.Close()
+	for _, chunk := range oplogChunks {
+		or, err := stg.SourceReader(chunk.FName)
+		if err != nil {
+			return errors.Wrapf(err, "get object %s for the storage", chunk.FName)
+		}
 
-	err = NewOplog(r.node, ver, preserveUUID).Apply(oplogReader)
-	if err != nil {
-		return errors.Wrap(err, "oplog apply")
+		oplogSrc, err := r.maybeDecrypt(rl.WrapReader(or), chunk.Encryption)
+		if err != nil {
+			or.Close()
+			return errors.Wrapf(err, "decrypt object %s", chunk.FName)
+		}
+
+		oplogReader, err := Decompress(oplogSrc, chunk.Compression)
+		if err != nil {
+			or.Close()
+			return errors.Wrapf(err, "decompress object %s", chunk.FName)
+		}
+
+		var src io.Reader = checksum.NewVerifyReader(oplogReader, chunk.Chunks)
+		if cmd.TargetTS.T != 0 {
+			src = newBoundedOplogReader(src, cmd.TargetTS)
+		}
+		src = withCancel(runCtx, src)
+
+		err = NewOplog(r.node, ver, preserveUUID).Apply(src)
+		oplogReader.Close()
+		or.Close()
+		if err != nil {
+			return errors.Wrapf(err, "oplog apply of chunk %s", chunk.FName)
+		}
 	}
 
 	cusr, err := r.node.CurrentUser()
@@ -281,6 +385,14 @@ func (r *Restore) Run(cmd pbm.RestoreCmd) (err error) {
 		return errors.Wrap(err, "restore users 'n' roles")
 	}
 
+	if cmd.ChecksumMode == ChecksumModeAfter {
+		// the data now matches the chain's tip backup, not the full one
+		err = r.verifyDBHashes(tipRS)
+		if err != nil {
+			return errors.Wrap(err, "post-restore checksum")
+		}
+	}
+
 	err = r.cn.ChangeRestoreRSState(cmd.Name, rsMeta.Name, pbm.StatusDone, "")
 	if err != nil {
 		return errors.Wrap(err, "set shard's StatusDone")